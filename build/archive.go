@@ -0,0 +1,268 @@
+// Copyright 2015 ThoughtWorks, Inc.
+
+// This file is part of Gauge.
+
+// Gauge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// Gauge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Gauge.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	archiveFormatTarGz = "tar.gz"
+	archiveFormatZip   = "zip"
+	archiveFormatBoth  = "both"
+	sha256Extension    = ".sha256"
+	sha1Extension      = ".sha1"
+)
+
+// reproducibleModTime is the fixed modification time stamped on every entry
+// of a reproducible archive so two builds of the same source tree produce
+// byte-identical output.
+var reproducibleModTime = time.Date(2010, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var archiveFormatFlag = flag.String("archive-format", "", "Specifies the archive format(s) to produce: tar.gz, zip or both. Defaults to tar.gz on linux/darwin and zip on windows.")
+var reproducible = flag.Bool("reproducible", false, "Produces reproducible archives: entries are sorted and stamped with a fixed modification time")
+
+// createArchives builds the distributable archive(s) for srcDir (a directory
+// under dir) into files named pkgName.<ext> inside dir, and writes a
+// .sha256/.sha1 checksum file next to each one. It replaces the previous
+// approach of shelling out to the system `zip` binary on unix and
+// powershell.exe's Compress-Archive on windows, so archive creation no
+// longer depends on what happens to be installed on the build host.
+func createArchives(env map[string]string, dir, srcDir, pkgName string) {
+	for _, format := range archiveFormatsFor(envOr(env, GOOS, getGOOS())) {
+		archivePath := filepath.Join(dir, pkgName+"."+format)
+		if err := createArchive(filepath.Join(dir, srcDir), archivePath, format); err != nil {
+			panic(fmt.Sprintf("Failed to create %s: %s", archivePath, err))
+		}
+		if err := writeChecksums(archivePath); err != nil {
+			panic(fmt.Sprintf("Failed to write checksums for %s: %s", archivePath, err))
+		}
+	}
+}
+
+func archiveFormatsFor(goOS string) []string {
+	switch *archiveFormatFlag {
+	case archiveFormatBoth:
+		return []string{archiveFormatTarGz, archiveFormatZip}
+	case archiveFormatTarGz, archiveFormatZip:
+		return []string{*archiveFormatFlag}
+	default:
+		if goOS == windows {
+			return []string{archiveFormatZip}
+		}
+		return []string{archiveFormatTarGz}
+	}
+}
+
+func createArchive(srcDir, archivePath, format string) error {
+	entries, err := sortedEntries(srcDir)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	switch format {
+	case archiveFormatZip:
+		return writeZip(out, srcDir, entries)
+	default:
+		return writeTarGz(out, srcDir, entries)
+	}
+}
+
+// sortedEntries returns every file/dir/symlink under srcDir, relative to it,
+// in a stable, platform-independent order so the resulting archive is
+// reproducible across machines.
+func sortedEntries(srcDir string) ([]string, error) {
+	var entries []string
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == srcDir {
+			return nil
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(entries)
+	return entries, nil
+}
+
+func entryModTime(info os.FileInfo) time.Time {
+	if *reproducible {
+		return reproducibleModTime
+	}
+	return info.ModTime()
+}
+
+func writeTarGz(out io.Writer, srcDir string, entries []string) error {
+	gw := gzip.NewWriter(out)
+	defer gw.Close()
+	tw := tar.NewWriter(gw)
+	defer tw.Close()
+
+	for _, entry := range entries {
+		fullPath := filepath.Join(srcDir, filepath.FromSlash(entry))
+		info, err := os.Lstat(fullPath)
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(fullPath); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = entry
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		header.ModTime = entryModTime(info)
+		header.AccessTime = time.Time{}
+		header.ChangeTime = time.Time{}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			f, err := os.Open(fullPath)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeZip(out io.Writer, srcDir string, entries []string) error {
+	zw := zip.NewWriter(out)
+	defer zw.Close()
+
+	for _, entry := range entries {
+		fullPath := filepath.Join(srcDir, filepath.FromSlash(entry))
+		info, err := os.Lstat(fullPath)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = entry
+		header.Modified = entryModTime(info)
+		header.Method = zip.Deflate
+		if info.IsDir() {
+			header.Name += "/"
+			header.Method = zip.Store
+		}
+		setUnixMode(header, info.Mode())
+
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(fullPath)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(w, target)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		f, err := os.Open(fullPath)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setUnixMode stores the unix file mode (including the symlink bit) in the
+// zip entry's external attributes, the same convention `zip`/`unzip` use, so
+// permissions and symlinks survive a round trip on linux and darwin.
+func setUnixMode(header *zip.FileHeader, mode os.FileMode) {
+	header.SetMode(mode)
+}
+
+func writeChecksums(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sha256Sum := sha256.New()
+	sha1Sum := sha1.New()
+	if _, err := io.Copy(io.MultiWriter(sha256Sum, sha1Sum), f); err != nil {
+		return err
+	}
+
+	name := filepath.Base(path)
+	if err := writeChecksumFile(path+sha256Extension, sha256Sum.Sum(nil), name); err != nil {
+		return err
+	}
+	return writeChecksumFile(path+sha1Extension, sha1Sum.Sum(nil), name)
+}
+
+func writeChecksumFile(path string, sum []byte, name string) error {
+	content := fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum), name)
+	return ioutil.WriteFile(path, []byte(content), 0644)
+}
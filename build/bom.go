@@ -0,0 +1,97 @@
+// Copyright 2015 ThoughtWorks, Inc.
+
+// This file is part of Gauge.
+
+// Gauge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// Gauge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Gauge.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+)
+
+// buildBomPureGo writes a minimal Bill-of-Materials for distroDir, covering
+// the plain file/directory/symlink layout our distributables actually ship
+// (no hard links, device nodes or resource forks). It is a fallback for
+// hosts without Xcode's `mkbom`; when byte-for-byte fidelity with Apple's
+// bomutils matters, prefer the system tool.
+//
+// The on-disk shape is a simplified layout of its own: a "BOMStore" magic and
+// version, a single named Paths block, and a flat list of every entry with its
+// stat info. It is not the tree-based BOM format mkbom writes, so lsbom and
+// Installer.app may not be able to read it.
+type bomEntry struct {
+	path string
+	mode uint32
+	uid  uint32
+	gid  uint32
+	size uint32
+}
+
+func buildBomPureGo(distroDir string) ([]byte, error) {
+	paths, err := sortedPkgPaths(distroDir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []bomEntry{{path: ".", mode: uint32(os.ModeDir | 0755)}}
+	for _, rel := range paths {
+		info, err := os.Lstat(filepath.Join(distroDir, filepath.FromSlash(rel)))
+		if err != nil {
+			return nil, err
+		}
+		mode := uint32(info.Mode().Perm())
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			mode |= 0120000
+		case info.IsDir():
+			mode |= 0040000
+		default:
+			mode |= 0100000
+		}
+		entries = append(entries, bomEntry{
+			path: "./" + rel,
+			mode: mode,
+			size: uint32(info.Size()),
+		})
+	}
+
+	var paths2 bytes.Buffer
+	binary.Write(&paths2, binary.BigEndian, uint32(len(entries)))
+	for _, e := range entries {
+		writeBomEntry(&paths2, e)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("BOMStore")
+	binary.Write(&out, binary.BigEndian, uint32(1)) // version
+	binary.Write(&out, binary.BigEndian, uint32(1)) // number of named blocks (Paths)
+	out.WriteString("Paths\x00\x00\x00")
+	binary.Write(&out, binary.BigEndian, uint32(paths2.Len()))
+	out.Write(paths2.Bytes())
+	return out.Bytes(), nil
+}
+
+func writeBomEntry(out *bytes.Buffer, e bomEntry) {
+	name := []byte(e.path)
+	binary.Write(out, binary.BigEndian, uint32(len(name)))
+	out.Write(name)
+	binary.Write(out, binary.BigEndian, e.mode)
+	binary.Write(out, binary.BigEndian, e.uid)
+	binary.Write(out, binary.BigEndian, e.gid)
+	binary.Write(out, binary.BigEndian, e.size)
+}
@@ -0,0 +1,125 @@
+// Copyright 2015 ThoughtWorks, Inc.
+
+// This file is part of Gauge.
+
+// Gauge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// Gauge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Gauge.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const cpioTrailer = "TRAILER!!!"
+
+// writeCpio writes paths (relative to srcDir, already in the order they
+// should appear in the archive) as a "newc" (SVR4 with no CRC) cpio archive,
+// the payload format macOS installer packages expect.
+func writeCpio(out io.Writer, srcDir string, paths []string) error {
+	inode := uint32(0)
+	for _, rel := range paths {
+		inode++
+		fullPath := filepath.Join(srcDir, filepath.FromSlash(rel))
+		info, err := os.Lstat(fullPath)
+		if err != nil {
+			return err
+		}
+
+		var data []byte
+		if info.Mode().IsRegular() {
+			if data, err = ioutil.ReadFile(fullPath); err != nil {
+				return err
+			}
+		} else if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(fullPath)
+			if err != nil {
+				return err
+			}
+			data = []byte(target)
+		}
+
+		if err := writeCpioEntry(out, "."+string(filepath.Separator)+rel, info, inode, data); err != nil {
+			return err
+		}
+	}
+	return writeCpioEntry(out, cpioTrailer, nil, inode+1, nil)
+}
+
+func writeCpioEntry(out io.Writer, name string, info os.FileInfo, inode uint32, data []byte) error {
+	mode := uint32(0)
+	if info != nil {
+		mode = cpioMode(info)
+	}
+	nameSize := len(name) + 1 // NUL terminated
+
+	header := fmt.Sprintf("070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+		inode,     // c_ino
+		mode,      // c_mode
+		0,         // c_uid
+		0,         // c_gid
+		1,         // c_nlink
+		0,         // c_mtime
+		len(data), // c_filesize
+		0,         // c_devmajor
+		0,         // c_devminor
+		0,         // c_rdevmajor
+		0,         // c_rdevminor
+		nameSize,  // c_namesize
+		0,         // c_check
+	)
+	if _, err := io.WriteString(out, header); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(out, name+"\x00"); err != nil {
+		return err
+	}
+	if err := writePad(out, len(header)+nameSize); err != nil {
+		return err
+	}
+	if _, err := out.Write(data); err != nil {
+		return err
+	}
+	return writePad(out, len(data))
+}
+
+// writePad pads out to the next 4-byte boundary, as the newc format requires
+// both the header+name and the file data to be 4-byte aligned.
+func writePad(out io.Writer, written int) error {
+	if pad := (4 - written%4) % 4; pad > 0 {
+		_, err := out.Write(make([]byte, pad))
+		return err
+	}
+	return nil
+}
+
+func cpioMode(info os.FileInfo) uint32 {
+	const (
+		cpioModeFile    = 0100000
+		cpioModeDir     = 0040000
+		cpioModeSymlink = 0120000
+	)
+	perm := uint32(info.Mode().Perm())
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return cpioModeSymlink | perm
+	case info.IsDir():
+		return cpioModeDir | perm
+	default:
+		return cpioModeFile | perm
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2015 ThoughtWorks, Inc.
+
+// This file is part of Gauge.
+
+// Gauge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// Gauge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Gauge.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+const gaugePkgIdentifier = "com.thoughtworks.gauge"
+
+var nativePkg = flag.Bool("native-pkg", false, "Builds the darwin .pkg in-process instead of shelling out to packagesbuild (requires Packages.app)")
+
+// buildNativeDarwinPkg assembles a flat macOS installer package for distroDir
+// without depending on the third-party Packages.app: the payload is built as
+// a gzipped cpio archive, the receipt as a Bom, and everything is wrapped in
+// a xar container, the three pieces a .pkg's on-disk format is defined by.
+func buildNativeDarwinPkg(distroDir, installLocation, outputPath string) error {
+	payload, err := buildPayload(distroDir)
+	if err != nil {
+		return fmt.Errorf("failed to build payload: %s", err)
+	}
+	bom, err := buildBom(distroDir)
+	if err != nil {
+		return fmt.Errorf("failed to build Bom: %s", err)
+	}
+	installKBytes, numberOfFiles, err := payloadStats(distroDir)
+	if err != nil {
+		return fmt.Errorf("failed to stat payload: %s", err)
+	}
+	packageInfo := []byte(buildPackageInfoXML(installLocation, installKBytes, numberOfFiles))
+	distribution := []byte(buildDistributionXML())
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return writeXar(out, []xarFile{
+		{name: "Distribution", data: distribution},
+		{name: "PackageInfo", data: packageInfo},
+		{name: "Bom", data: bom},
+		{name: "Payload", data: payload},
+	})
+}
+
+// buildPayload walks distroDir and produces a gzip-compressed cpio (newc)
+// archive of its contents, the format macOS installer packages expect for
+// their Payload entry.
+func buildPayload(distroDir string) ([]byte, error) {
+	paths, err := sortedPkgPaths(distroDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var cpioBuf bytes.Buffer
+	if err := writeCpio(&cpioBuf, distroDir, paths); err != nil {
+		return nil, err
+	}
+
+	var gzBuf bytes.Buffer
+	gw := gzip.NewWriter(&gzBuf)
+	if _, err := gw.Write(cpioBuf.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return gzBuf.Bytes(), nil
+}
+
+// buildBom prefers the system `mkbom` (part of Xcode command line tools),
+// falling back to a minimal pure-Go writer when it isn't installed. The
+// fallback doesn't implement the real BOM format (see buildBomPureGo), so
+// the resulting .pkg's receipt may not be readable by lsbom/Installer.app;
+// warn loudly so a maintainer building on a host without Xcode CLT notices.
+func buildBom(distroDir string) ([]byte, error) {
+	if mkbomPath, err := exec.LookPath("mkbom"); err == nil {
+		return runMkbom(mkbomPath, distroDir)
+	}
+	log.Printf("WARNING: mkbom not found on PATH; falling back to a minimal Bom writer that Installer.app/lsbom may not be able to read. Install Xcode command line tools for a correct .pkg.")
+	return buildBomPureGo(distroDir)
+}
+
+func runMkbom(mkbomPath, distroDir string) ([]byte, error) {
+	tmp, err := ioutil.TempFile("", "gauge-bom")
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	cmd := exec.Command(mkbomPath, distroDir, tmpPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(tmpPath)
+}
+
+func sortedPkgPaths(distroDir string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(distroDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == distroDir {
+			return nil
+		}
+		rel, err := filepath.Rel(distroDir, path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// payloadStats sums the on-disk size (in KB, rounded up) and counts the
+// regular files under distroDir, the numbers Installer.app shows for a
+// package's payload and records in PackageInfo.
+func payloadStats(distroDir string) (installKBytes, numberOfFiles int64, err error) {
+	paths, err := sortedPkgPaths(distroDir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var totalBytes int64
+	for _, rel := range paths {
+		info, err := os.Lstat(filepath.Join(distroDir, filepath.FromSlash(rel)))
+		if err != nil {
+			return 0, 0, err
+		}
+		if info.Mode().IsRegular() {
+			totalBytes += info.Size()
+			numberOfFiles++
+		}
+	}
+	return (totalBytes + 1023) / 1024, numberOfFiles, nil
+}
+
+func buildPackageInfoXML(installLocation string, installKBytes, numberOfFiles int64) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<pkg-info identifier="%s" version="%s" install-location="%s" auth="root">
+  <payload installKBytes="%d" numberOfFiles="%d"/>
+</pkg-info>
+`, gaugePkgIdentifier, getBuildVersion(), installLocation, installKBytes, numberOfFiles)
+}
+
+func buildDistributionXML() string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<installer-gui-script minSpecVersion="1">
+  <title>Gauge</title>
+  <options customize="never" require-scripts="false"/>
+  <choices-outline>
+    <line choice="default">
+      <line choice="%s"/>
+    </line>
+  </choices-outline>
+  <choice id="default"/>
+  <choice id="%s" visible="false">
+    <pkg-ref id="%s"/>
+  </choice>
+  <pkg-ref id="%s" version="%s" onConclusion="none">%s.pkg</pkg-ref>
+</installer-gui-script>
+`, gaugePkgIdentifier, gaugePkgIdentifier, gaugePkgIdentifier, gaugePkgIdentifier, getBuildVersion(), gauge)
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 ThoughtWorks, Inc.
+
+// This file is part of Gauge.
+
+// Gauge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// Gauge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Gauge.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	buildModeExe      = "exe"
+	buildModeCArchive = "c-archive"
+	buildModeCShared  = "c-shared"
+
+	// gaugeAPIPackage is the subset of gauge that third parties embed: the
+	// spec parser and execution API, exported via cgo for c-archive/c-shared
+	// consumers (IDE plugins, C/C++ tools).
+	gaugeAPIPackage = "github.com/getgauge/gauge/api"
+)
+
+var (
+	libDir     = "lib"
+	includeDir = filepath.Join("include", gauge)
+)
+
+var buildMode = flag.String("buildmode", buildModeExe, "Build mode for gauge: exe (default), c-archive or c-shared, to embed the spec parser and execution api into non-Go hosts")
+var exportHeaderOnly = flag.Bool("exportheader-only", false, "Only (re)generates the cgo export header for the library build modes, skipping the archive/shared object build")
+
+func compileGaugeLibrary(env map[string]string) error {
+	libPath := getGaugeLibraryPath(env)
+	headerPath := getGaugeExportHeaderPath(env)
+
+	if err := os.MkdirAll(filepath.Dir(libPath), 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(headerPath), 0755); err != nil {
+		return err
+	}
+
+	if !*exportHeaderOnly {
+		if err := runProcess(env, "go", "build", "-buildmode="+*buildMode, "-o", libPath, gaugeAPIPackage); err != nil {
+			return err
+		}
+	}
+	return generateExportHeader(env, headerPath)
+}
+
+// generateExportHeader writes the C header for the //export'ed symbols
+// declared in gaugeAPIPackage's own source files; symbols exported by the
+// packages it imports are not included. It shells out to `go tool cgo
+// -exportheader` directly instead of priming the build with `go install -i`,
+// which would try to write compiled packages into a possibly read-only
+// GOROOT.
+func generateExportHeader(env map[string]string, headerPath string) error {
+	pkgDir, err := packageDir(env, gaugeAPIPackage)
+	if err != nil {
+		return fmt.Errorf("failed to locate %s: %s", gaugeAPIPackage, err)
+	}
+	files, err := filepath.Glob(filepath.Join(pkgDir, "*.go"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no cgo sources found to export a header from under %s", gaugeAPIPackage)
+	}
+	args := append([]string{"tool", "cgo", "-exportheader", headerPath}, files...)
+	return runProcess(env, "go", args...)
+}
+
+// packageDir resolves importPath's source directory with `go list`, which
+// works under Go modules as well as the legacy GOPATH/src layout - unlike
+// assuming $GOPATH/src/<import path>, which a module-mode checkout of this
+// repo won't be sitting under.
+func packageDir(env map[string]string, importPath string) (string, error) {
+	return runProcessOutput(env, "go", "list", "-f", "{{.Dir}}", importPath)
+}
+
+func getGaugeLibraryPath(env map[string]string) string {
+	return filepath.Join(getBinDir(env), libDir, libraryFileName(env))
+}
+
+func getGaugeExportHeaderPath(env map[string]string) string {
+	return filepath.Join(getBinDir(env), includeDir, gauge+".h")
+}
+
+func libraryFileName(env map[string]string) string {
+	switch *buildMode {
+	case buildModeCShared:
+		switch envOr(env, GOOS, getGOOS()) {
+		case darwin:
+			return gauge + ".dylib"
+		case windows:
+			return gauge + ".dll"
+		default:
+			return gauge + ".so"
+		}
+	default:
+		return gauge + ".a"
+	}
+}
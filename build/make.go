@@ -20,12 +20,14 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/getgauge/common"
@@ -36,11 +38,17 @@ const (
 	CGO_ENABLED        = "CGO_ENABLED"
 	GOARCH             = "GOARCH"
 	GOOS               = "GOOS"
+	GOARM              = "GOARM"
+	GOAMD64            = "GOAMD64"
 	X86                = "386"
 	X86_64             = "amd64"
+	ARM                = "arm"
+	ARM64              = "arm64"
 	darwin             = "darwin"
 	linux              = "linux"
 	windows            = "windows"
+	freebsd            = "freebsd"
+	netbsd             = "netbsd"
 	bin                = "bin"
 	gauge              = "gauge"
 	gaugeScreenshot    = "gauge_screenshot"
@@ -58,28 +66,58 @@ var gaugeScreenshotLocation = filepath.Join("github.com", "getgauge", "gauge_scr
 
 var deployDir = filepath.Join(deploy, gauge)
 
-func runProcess(command string, arg ...string) {
+// runProcess runs command with env layered on top of the current process
+// environment (via exec.Cmd.Env, never os.Setenv), so concurrent callers
+// building different targets don't stomp on each other's GOOS/GOARCH/CC. A
+// nil env just inherits the process environment unchanged.
+func runProcess(env map[string]string, command string, arg ...string) error {
 	cmd := exec.Command(command, arg...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	log.Printf("Execute %v\n", cmd.Args)
-	err := cmd.Run()
-	if err != nil {
+	cmd.Env = mergeEnv(os.Environ(), env)
+	log.Printf("Execute %v (env overrides: %v)\n", cmd.Args, env)
+	return cmd.Run()
+}
+
+// mustRunProcess is runProcess for the many call sites that only ever ran
+// sequentially and expect a build failure to abort the whole program.
+func mustRunProcess(env map[string]string, command string, arg ...string) {
+	if err := runProcess(env, command, arg...); err != nil {
 		panic(err)
 	}
 }
 
-func runCommand(command string, arg ...string) (string, error) {
+// runProcessOutput is runProcess for the few call sites (like `go list`)
+// that need the command's stdout rather than just its exit status.
+func runProcessOutput(env map[string]string, command string, arg ...string) (string, error) {
 	cmd := exec.Command(command, arg...)
-	bytes, err := cmd.Output()
-	return strings.TrimSpace(fmt.Sprintf("%s", bytes)), err
+	cmd.Stderr = os.Stderr
+	cmd.Env = mergeEnv(os.Environ(), env)
+	log.Printf("Execute %v (env overrides: %v)\n", cmd.Args, env)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
 }
 
-func signExecutable(exeFilePath string, certFilePath string, certFilePwd string) {
-	if getGOOS() == windows {
+func mergeEnv(base []string, overrides map[string]string) []string {
+	if len(overrides) == 0 {
+		return base
+	}
+	env := make([]string, 0, len(base)+len(overrides))
+	env = append(env, base...)
+	for k, v := range overrides {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+func signExecutable(env map[string]string, exeFilePath string, certFilePath string, certFilePwd string) {
+	if envOr(env, GOOS, getGOOS()) == windows {
 		if certFilePath != "" && certFilePwd != "" {
 			log.Printf("Signing: %s", exeFilePath)
-			runProcess("signtool", "sign", "/f", certFilePath, "/p", certFilePwd, exeFilePath)
+			mustRunProcess(nil, "signtool", "sign", "/f", certFilePath, "/p", certFilePwd, exeFilePath)
 		} else {
 			log.Printf("No certificate file passed. Executable won't be signed.")
 		}
@@ -96,29 +134,45 @@ func getBuildVersion() string {
 }
 
 func compileGauge() {
-	executablePath := getGaugeExecutablePath(gauge)
-	runProcess("go", "build", "-ldflags", "-X github.com/getgauge/gauge/version.BuildMetadata="+buildMetadata, "-o", executablePath)
-	compileGaugeScreenshot()
+	if err := compileGaugeFor(nil); err != nil {
+		panic(err)
+	}
+}
+
+// compileGaugeFor builds gauge with the given per-invocation env layered on
+// top of the process environment, so it's safe to call concurrently for
+// different targets from crossCompileGauge.
+func compileGaugeFor(env map[string]string) error {
+	if *buildMode != buildModeExe {
+		return compileGaugeLibrary(env)
+	}
+	executablePath := getGaugeExecutablePath(env, gauge)
+	if err := runProcess(env, "go", "build", "-ldflags", "-X github.com/getgauge/gauge/version.BuildMetadata="+buildMetadata, "-o", executablePath); err != nil {
+		return err
+	}
+	return compileGaugeScreenshot(env)
 }
 
-func compileGaugeScreenshot() {
-	getGaugeScreenshot()
-	executablePath := getGaugeExecutablePath(gaugeScreenshot)
-	runProcess("go", "build", "-o", executablePath, gaugeScreenshotLocation)
+func compileGaugeScreenshot(env map[string]string) error {
+	if err := getGaugeScreenshot(); err != nil {
+		return err
+	}
+	executablePath := getGaugeExecutablePath(env, gaugeScreenshot)
+	return runProcess(env, "go", "build", "-o", executablePath, gaugeScreenshotLocation)
 }
 
-func getGaugeScreenshot() {
-	runProcess("go", "get", "-u", "-d", gaugeScreenshotLocation)
+func getGaugeScreenshot() error {
+	return runProcess(nil, "go", "get", "-u", "-d", gaugeScreenshotLocation)
 }
 
 func runTests(coverage bool) {
 	if coverage {
-		runProcess("go", "test", "-covermode=count", "-coverprofile=count.out")
+		mustRunProcess(nil, "go", "test", "-covermode=count", "-coverprofile=count.out")
 		if coverage {
-			runProcess("go", "tool", "cover", "-html=count.out")
+			mustRunProcess(nil, "go", "tool", "cover", "-html=count.out")
 		}
 	} else {
-		runProcess("go", "test", "./...", "-v")
+		mustRunProcess(nil, "go", "test", "./...", "-v")
 	}
 }
 
@@ -143,22 +197,28 @@ func installFiles(files map[string]string, installDir string) {
 	}
 }
 
-func copyGaugeFiles(installPath string) {
+func copyGaugeFiles(env map[string]string, installPath string) {
 	files := make(map[string]string)
-	files[getGaugeExecutablePath(gauge)] = bin
-	files[getGaugeExecutablePath(gaugeScreenshot)] = bin
+	if *buildMode != buildModeExe {
+		files[getGaugeLibraryPath(env)] = libDir
+		files[getGaugeExportHeaderPath(env)] = includeDir
+	} else {
+		files[getGaugeExecutablePath(env, gauge)] = bin
+		files[getGaugeExecutablePath(env, gaugeScreenshot)] = bin
+	}
 	files[filepath.Join("skel", "example.spec")] = filepath.Join("share", gauge, "skel")
 	files[filepath.Join("skel", "default.properties")] = filepath.Join("share", gauge, "skel", "env")
 	files[filepath.Join("skel", "gauge.properties")] = filepath.Join("share", gauge)
 	files[filepath.Join("notice.md")] = filepath.Join("share", gauge)
-	files = addInstallScripts(files)
+	files = addInstallScripts(env, files)
 	installFiles(files, installPath)
 }
 
-func addInstallScripts(files map[string]string) map[string]string {
-	if (getGOOS() == darwin || getGOOS() == linux) && (*distro) {
+func addInstallScripts(env map[string]string, files map[string]string) map[string]string {
+	goOS := envOr(env, GOOS, getGOOS())
+	if (goOS == darwin || goOS == linux) && (*distro) {
 		files[filepath.Join("build", "install", installShellScript)] = ""
-	} else if getGOOS() == windows {
+	} else if goOS == windows {
 		files[filepath.Join("build", "install", "windows", "plugin-install.bat")] = ""
 		files[filepath.Join("build", "install", "windows", "backup_properties_file.bat")] = ""
 		files[filepath.Join("build", "install", "windows", "set_timestamp.bat")] = ""
@@ -166,12 +226,6 @@ func addInstallScripts(files map[string]string) map[string]string {
 	return files
 }
 
-func setEnv(envVariables map[string]string) {
-	for k, v := range envVariables {
-		os.Setenv(k, v)
-	}
-}
-
 var test = flag.Bool("test", false, "Run the test cases")
 var coverage = flag.Bool("coverage", false, "Run the test cases and show the coverage")
 var install = flag.Bool("install", false, "Install to the specified prefix")
@@ -184,6 +238,7 @@ var distro = flag.Bool("distro", false, "Create gauge distributable")
 var skipWindowsDistro = flag.Bool("skip-windows", false, "Skips creation of windows distributable on unix machines while cross platform compilation")
 var certFile = flag.String("certFile", "", "Should be passed for signing the windows installer along with the password (certFilePwd)")
 var certFilePwd = flag.String("certFilePwd", "", "Password for certificate that will be used to sign the windows installer")
+var targets = flag.String("targets", "", "Comma separated os/arch pairs to compile for, e.g. linux/arm64,darwin/arm64. Supersedes --target-linux and --all-platforms")
 
 // Defines all the compile targets
 // Each target name is the directory name
@@ -191,12 +246,27 @@ var (
 	platformEnvs = []map[string]string{
 		map[string]string{GOARCH: X86, GOOS: darwin, CGO_ENABLED: "0"},
 		map[string]string{GOARCH: X86_64, GOOS: darwin, CGO_ENABLED: "0"},
+		map[string]string{GOARCH: ARM64, GOOS: darwin, CGO_ENABLED: "0"},
 		map[string]string{GOARCH: X86, GOOS: linux, CGO_ENABLED: "0"},
 		map[string]string{GOARCH: X86_64, GOOS: linux, CGO_ENABLED: "0"},
+		map[string]string{GOARCH: ARM, GOOS: linux, GOARM: "7", CC: "arm-linux-gnueabihf-gcc", CGO_ENABLED: "1"},
+		map[string]string{GOARCH: ARM64, GOOS: linux, CC: "aarch64-linux-gnu-gcc", CGO_ENABLED: "1"},
 		map[string]string{GOARCH: X86, GOOS: windows, CC: "i586-mingw32-gcc", CGO_ENABLED: "1"},
 		map[string]string{GOARCH: X86_64, GOOS: windows, CC: "x86_64-w64-mingw32-gcc", CGO_ENABLED: "1"},
+		map[string]string{GOARCH: X86_64, GOOS: freebsd, CGO_ENABLED: "0"},
+		map[string]string{GOARCH: X86_64, GOOS: netbsd, CGO_ENABLED: "0"},
 	}
 	osDistroMap = map[string]distroFunc{windows: createWindowsDistro, linux: createLinuxPackage, darwin: createDarwinPackage}
+
+	// crossCompilers maps a os/arch pair to the cross compiler CC should be
+	// set to for cgo-enabled builds, mirroring the toolchains platformEnvs
+	// above already uses for windows.
+	crossCompilers = map[string]string{
+		linux + "/" + ARM:      "arm-linux-gnueabihf-gcc",
+		linux + "/" + ARM64:    "aarch64-linux-gnu-gcc",
+		windows + "/" + X86:    "i586-mingw32-gcc",
+		windows + "/" + X86_64: "x86_64-w64-mingw32-gcc",
+	}
 )
 
 func main() {
@@ -209,9 +279,9 @@ func main() {
 	} else if *install {
 		installGauge()
 	} else if *distro {
-		createGaugeDistributables(*allPlatforms)
+		createGaugeDistributables(wantsMultiTarget())
 	} else {
-		if *allPlatforms {
+		if wantsMultiTarget() {
 			crossCompileGauge()
 		} else {
 			compileGauge()
@@ -219,7 +289,17 @@ func main() {
 	}
 }
 
+// wantsMultiTarget reports whether the build should fan out across more than
+// one platform: either --all-platforms, or an explicit --targets selector,
+// which supersedes it.
+func wantsMultiTarget() bool {
+	return *targets != "" || *allPlatforms
+}
+
 func filteredPlatforms() []map[string]string {
+	if *targets != "" {
+		return parseTargets(*targets)
+	}
 	filteredPlatformEnvs := platformEnvs[:0]
 	for _, x := range platformEnvs {
 		if *targetLinux {
@@ -233,113 +313,195 @@ func filteredPlatforms() []map[string]string {
 	return filteredPlatformEnvs
 }
 
+// parseTargets turns a --targets value like "linux/arm64,darwin/amd64" into
+// the same []map[string]string shape as platformEnvs, deriving the right
+// GOARM/GOAMD64 and CC settings for each pair.
+func parseTargets(spec string) []map[string]string {
+	var envs []map[string]string
+	for _, target := range strings.Split(spec, ",") {
+		target = strings.TrimSpace(target)
+		parts := strings.SplitN(target, "/", 2)
+		if len(parts) != 2 {
+			panic(fmt.Sprintf("Invalid --targets entry %q, expected os/arch", target))
+		}
+		envs = append(envs, targetEnv(parts[0], parts[1]))
+	}
+	return envs
+}
+
+func targetEnv(goos, goarch string) map[string]string {
+	env := map[string]string{GOOS: goos, GOARCH: goarch, CGO_ENABLED: "0"}
+	if cc, ok := crossCompilers[goos+"/"+goarch]; ok {
+		env[CC] = cc
+		env[CGO_ENABLED] = "1"
+	}
+	switch goarch {
+	case ARM:
+		env[GOARM] = "7"
+	case X86_64:
+		env[GOAMD64] = "v1"
+	}
+	return env
+}
+
+var jobs = flag.Int("jobs", runtime.NumCPU(), "Number of cross-compile targets to build in parallel")
+
+// crossCompileGauge fans the requested targets out across a worker pool
+// bounded by --jobs. Each target gets its own env (never a process-wide
+// os.Setenv) and its own GOCACHE/GOTMPDIR under a private temp dir, so
+// parallel `go build` invocations don't collide. A single target failing
+// doesn't stop the others; every failure is collected and reported together.
 func crossCompileGauge() {
-	for _, platformEnv := range filteredPlatforms() {
-		setEnv(platformEnv)
-		log.Printf("Compiling for platform => OS:%s ARCH:%s \n", platformEnv[GOOS], platformEnv[GOARCH])
-		compileGauge()
+	platformsToBuild := filteredPlatforms()
+	sem := make(chan struct{}, maxJobs())
+	errs := make([]error, len(platformsToBuild))
+
+	var wg sync.WaitGroup
+	for i, platformEnv := range platformsToBuild {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, platformEnv map[string]string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			log.Printf("Compiling for platform => OS:%s ARCH:%s \n", platformEnv[GOOS], platformEnv[GOARCH])
+			errs[i] = compileGaugeForTarget(platformEnv)
+		}(i, platformEnv)
+	}
+	wg.Wait()
+
+	var failures []string
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s/%s: %s", platformsToBuild[i][GOOS], platformsToBuild[i][GOARCH], err))
+		}
+	}
+	if len(failures) > 0 {
+		panic(fmt.Sprintf("%d of %d cross-compile target(s) failed:\n%s", len(failures), len(platformsToBuild), strings.Join(failures, "\n")))
 	}
 }
 
+func maxJobs() int {
+	if *jobs > 0 {
+		return *jobs
+	}
+	return 1
+}
+
+// compileGaugeForTarget gives platformEnv its own GOCACHE/GOTMPDIR under a
+// private temp directory before compiling, so it can safely run alongside
+// other targets' builds.
+func compileGaugeForTarget(platformEnv map[string]string) error {
+	tmpDir, err := ioutil.TempDir("", fmt.Sprintf("gauge-build-%s-%s-", platformEnv[GOOS], platformEnv[GOARCH]))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	env := make(map[string]string, len(platformEnv)+2)
+	for k, v := range platformEnv {
+		env[k] = v
+	}
+	env["GOCACHE"] = filepath.Join(tmpDir, "cache")
+	env["GOTMPDIR"] = filepath.Join(tmpDir, "tmp")
+	if err := os.MkdirAll(env["GOCACHE"], 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(env["GOTMPDIR"], 0755); err != nil {
+		return err
+	}
+
+	return compileGaugeFor(env)
+}
+
 func installGauge() {
 	updateGaugeInstallPrefix()
-	copyGaugeFiles(deployDir)
+	copyGaugeFiles(nil, deployDir)
 	if _, err := common.MirrorDir(deployDir, *gaugeInstallPrefix); err != nil {
 		panic(fmt.Sprintf("Could not install gauge : %s", err))
 	}
 }
 
+// createGaugeDistributables builds one distro per requested platform. Like
+// crossCompileGauge, each platform's GOOS/GOARCH/CC are passed down as an
+// explicit env rather than set process-wide, so this never races or leaves
+// state behind for the next platform in the loop.
 func createGaugeDistributables(forAllPlatforms bool) {
 	if forAllPlatforms {
 		for _, platformEnv := range filteredPlatforms() {
-			setEnv(platformEnv)
 			log.Printf("Creating distro for platform => OS:%s ARCH:%s \n", platformEnv[GOOS], platformEnv[GOARCH])
-			createDistro()
+			createDistro(platformEnv)
 		}
 	} else {
-		createDistro()
+		createDistro(nil)
 	}
+	finalizeRelease()
 }
 
-type distroFunc func()
+type distroFunc func(map[string]string)
 
-func createDistro() {
-	osDistroMap[getGOOS()]()
+func createDistro(env map[string]string) {
+	distroFn, ok := osDistroMap[envOr(env, GOOS, getGOOS())]
+	if !ok {
+		// freebsd/netbsd have no installer of their own: ship the plain archive.
+		distroFn = createLinuxPackage
+	}
+	distroFn(env)
 }
 
-func createWindowsDistro() {
+func createWindowsDistro(env map[string]string) {
 	if !*skipWindowsDistro {
-		createWindowsInstaller()
+		createWindowsInstaller(env)
 	}
 }
 
-func createWindowsInstaller() {
-	pName := packageName()
+func createWindowsInstaller(env map[string]string) {
+	pName := packageName(env)
 	distroDir, err := filepath.Abs(filepath.Join(deploy, pName))
 	installerFileName := filepath.Join(filepath.Dir(distroDir), pName)
 	if err != nil {
 		panic(err)
 	}
-	copyGaugeFiles(distroDir)
-	createZipFromUtil(deploy, pName, pName)
-	runProcess("makensis.exe",
+	copyGaugeFiles(env, distroDir)
+	createArchives(env, deploy, pName, pName)
+	mustRunProcess(nil, "makensis.exe",
 		fmt.Sprintf("/DPRODUCT_VERSION=%s", getBuildVersion()),
 		fmt.Sprintf("/DGAUGE_DISTRIBUTABLES_DIR=%s", distroDir),
 		fmt.Sprintf("/DOUTPUT_FILE_NAME=%s.exe", installerFileName),
 		filepath.Join("build", "install", "windows", "gauge-install.nsi"))
 	os.RemoveAll(distroDir)
-	signExecutable(installerFileName+".exe", *certFile, *certFilePwd)
+	signExecutable(env, installerFileName+".exe", *certFile, *certFilePwd)
 }
 
-func createDarwinPackage() {
+func createDarwinPackage(env map[string]string) {
 	distroDir := filepath.Join(deploy, gauge)
-	copyGaugeFiles(distroDir)
-	createZipFromUtil(deploy, gauge, packageName())
-	runProcess(packagesBuild, "-v", darwinPackageProject)
-	runProcess("mv", filepath.Join(deploy, gauge+pkg), filepath.Join(deploy, fmt.Sprintf("%s-%s-%s.%s%s", gauge, getBuildVersion(), getGOOS(), getPackageArchSuffix(), pkg)))
+	copyGaugeFiles(env, distroDir)
+	createArchives(env, deploy, gauge, packageName(env))
+	pkgPath := filepath.Join(deploy, fmt.Sprintf("%s-%s-%s.%s%s", gauge, getBuildVersion(), envOr(env, GOOS, getGOOS()), getPackageArchSuffix(env), pkg))
+	if *nativePkg {
+		if err := buildNativeDarwinPkg(distroDir, "/usr/local", pkgPath); err != nil {
+			log.Printf("Native pkg build failed, falling back to packagesbuild: %s", err)
+			createPackagesBuildDistro(pkgPath)
+		}
+	} else {
+		createPackagesBuildDistro(pkgPath)
+	}
 	os.RemoveAll(distroDir)
 }
 
-func createLinuxPackage() {
-	distroDir := filepath.Join(deploy, packageName())
-	copyGaugeFiles(distroDir)
-	createZipFromUtil(deploy, packageName(), packageName())
-	os.RemoveAll(distroDir)
+func createPackagesBuildDistro(pkgPath string) {
+	mustRunProcess(nil, packagesBuild, "-v", darwinPackageProject)
+	mustRunProcess(nil, "mv", filepath.Join(deploy, gauge+pkg), pkgPath)
 }
 
-func packageName() string {
-	return fmt.Sprintf("%s-%s-%s.%s", gauge, getBuildVersion(), getGOOS(), getPackageArchSuffix())
+func createLinuxPackage(env map[string]string) {
+	distroDir := filepath.Join(deploy, packageName(env))
+	copyGaugeFiles(env, distroDir)
+	createArchives(env, deploy, packageName(env), packageName(env))
+	os.RemoveAll(distroDir)
 }
 
-func createZipFromUtil(dir, zipDir, pkgName string) {
-	wd, err := os.Getwd()
-	if err != nil {
-		panic(err)
-	}
-	absdir, err := filepath.Abs(dir)
-	if err != nil {
-		panic(err)
-	}
-
-	windowsZipScript := filepath.Join(wd, "build", "create_windows_zipfile.ps1")
-
-	err = os.Chdir(filepath.Join(dir, zipDir))
-	if err != nil {
-		panic(fmt.Sprintf("Failed to change directory: %s", err))
-	}
-
-	zipcmd := "zip"
-	zipargs := []string{"-r", filepath.Join("..", pkgName+".zip"), "."}
-	if getGOOS() == "windows" {
-		zipcmd = "powershell.exe"
-		zipargs = []string{"-noprofile", "-executionpolicy", "bypass", "-file", windowsZipScript, filepath.Join(absdir, zipDir), filepath.Join(absdir, pkgName+".zip")}
-	}
-	output, err := runCommand(zipcmd, zipargs...)
-	fmt.Println(output)
-	if err != nil {
-		panic(fmt.Sprintf("Failed to zip: %s", err))
-	}
-	os.Chdir(wd)
+func packageName(env map[string]string) string {
+	return fmt.Sprintf("%s-%s-%s.%s", gauge, getBuildVersion(), envOr(env, GOOS, getGOOS()), getPackageArchSuffix(env))
 }
 
 func updateGaugeInstallPrefix() {
@@ -356,24 +518,35 @@ func updateGaugeInstallPrefix() {
 	}
 }
 
-func getGaugeExecutablePath(file string) string {
-	return filepath.Join(getBinDir(), getExecutableName(file))
+// getGaugeExecutablePath, getBinDir and getExecutableName take an optional
+// per-target env (as produced by targetEnv/platformEnvs) so cross-compile
+// workers can resolve GOOS/GOARCH without touching the process environment.
+// Pass nil to fall back to the process's own GOOS/GOARCH.
+func getGaugeExecutablePath(env map[string]string, file string) string {
+	return filepath.Join(getBinDir(env), getExecutableName(env, file))
 }
 
-func getBinDir() string {
+func getBinDir(env map[string]string) string {
 	if *binDir != "" {
 		return *binDir
 	}
-	return filepath.Join(bin, fmt.Sprintf("%s_%s", getGOOS(), getGOARCH()))
+	return filepath.Join(bin, fmt.Sprintf("%s_%s", envOr(env, GOOS, getGOOS()), envOr(env, GOARCH, getGOARCH())))
 }
 
-func getExecutableName(file string) string {
-	if getGOOS() == windows {
+func getExecutableName(env map[string]string, file string) string {
+	if envOr(env, GOOS, getGOOS()) == windows {
 		return file + ".exe"
 	}
 	return file
 }
 
+func envOr(env map[string]string, key, fallback string) string {
+	if v, ok := env[key]; ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
 func getGOARCH() string {
 	goArch := os.Getenv(GOARCH)
 	if goArch == "" {
@@ -390,7 +563,7 @@ func getGOOS() string {
 	return goOS
 }
 
-func getPackageArchSuffix() string {
+func getPackageArchSuffix(env map[string]string) string {
 	if strings.HasSuffix(*binDir, "386") {
 		return "x86"
 	}
@@ -399,8 +572,22 @@ func getPackageArchSuffix() string {
 		return "x86_64"
 	}
 
-	if arch := getGOARCH(); arch == X86 {
+	if strings.HasSuffix(*binDir, "arm64") {
+		return "arm64"
+	}
+
+	if strings.HasSuffix(*binDir, "arm") {
+		return "arm"
+	}
+
+	switch arch := envOr(env, GOARCH, getGOARCH()); arch {
+	case X86:
 		return "x86"
+	case ARM:
+		return "arm"
+	case ARM64:
+		return "arm64"
+	default:
+		return "x86_64"
 	}
-	return "x86_64"
 }
@@ -0,0 +1,295 @@
+// Copyright 2015 ThoughtWorks, Inc.
+
+// This file is part of Gauge.
+
+// Gauge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// Gauge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Gauge.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sha256SumsFile    = "SHA256SUMS"
+	sha256SumsSigFile = sha256SumsFile + ".asc"
+	uploadRetries     = 3
+	uploadRetryDelay  = 2 * time.Second
+
+	awsSigningAlgorithm = "AWS4-HMAC-SHA256"
+	awsRequestType      = "aws4_request"
+	awsService          = "s3"
+	amzDateFormat       = "20060102T150405Z"
+	amzDateStampFormat  = "20060102"
+)
+
+var gpgKey = flag.String("gpg-key", "", "Path to the GPG private key used to sign SHA256SUMS")
+var gpgPassphrase = flag.String("gpg-passphrase", "", "Passphrase for the GPG signing key")
+var upload = flag.Bool("upload", false, "Uploads deploy/ artifacts and SHA256SUMS to the object store configured by --upload-*")
+var uploadEndpoint = flag.String("upload-endpoint", "", "S3-compatible endpoint to upload release artifacts to")
+var uploadBucket = flag.String("upload-bucket", "", "Bucket to upload release artifacts to")
+var uploadPrefix = flag.String("upload-prefix", "", "Key prefix to upload release artifacts under")
+var uploadRegion = flag.String("upload-region", "us-east-1", "Region to use when SigV4-signing uploads to --upload-endpoint")
+
+// finalizeRelease runs once createGaugeDistributables has built every
+// requested artifact: it writes a SHA256SUMS manifest covering deploy/, GPG
+// signs it when a key is configured, and uploads everything when --upload is
+// set. This gives releases a single self-contained build+sign+publish step.
+func finalizeRelease() {
+	sumsPath, err := writeSHA256Sums(deploy)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to write %s: %s", sha256SumsFile, err))
+	}
+	if *gpgKey != "" {
+		if err := signManifest(sumsPath); err != nil {
+			panic(fmt.Sprintf("Failed to sign %s: %s", sha256SumsFile, err))
+		}
+	}
+	if *upload {
+		if err := uploadRelease(deploy); err != nil {
+			panic(fmt.Sprintf("Failed to upload release artifacts: %s", err))
+		}
+	}
+}
+
+// writeSHA256Sums hashes every regular file directly under deployDir and
+// writes them, sha256sum(1)-style, to deployDir/SHA256SUMS. The per-archive
+// .sha256/.sha1 side files createArchives already wrote are skipped so the
+// aggregate manifest doesn't end up hashing other files' checksums.
+func writeSHA256Sums(deployDir string) (string, error) {
+	infos, err := ioutil.ReadDir(deployDir)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, info := range infos {
+		if info.IsDir() || info.Name() == sha256SumsFile || info.Name() == sha256SumsSigFile {
+			continue
+		}
+		if strings.HasSuffix(info.Name(), sha256Extension) || strings.HasSuffix(info.Name(), sha1Extension) {
+			continue
+		}
+		sum, err := sha256File(filepath.Join(deployDir, info.Name()))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s  %s\n", sum, info.Name())
+	}
+
+	sumsPath := filepath.Join(deployDir, sha256SumsFile)
+	if err := ioutil.WriteFile(sumsPath, []byte(b.String()), 0644); err != nil {
+		return "", err
+	}
+	return sumsPath, nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// signManifest imports the private key at --gpg-key into gpg's default
+// keyring and produces a detached, armored signature for sumsPath at
+// sumsPath+".asc", so release consumers can verify SHA256SUMS with
+// `gpg --verify` before trusting the checksums it lists.
+func signManifest(sumsPath string) error {
+	if err := runProcess(nil, "gpg", "--batch", "--yes", "--import", *gpgKey); err != nil {
+		return err
+	}
+
+	args := []string{"--batch", "--yes"}
+	if *gpgPassphrase != "" {
+		args = append(args, "--pinentry-mode", "loopback", "--passphrase", *gpgPassphrase)
+	}
+	args = append(args, "--armor", "--detach-sign", "--output", sumsPath+".asc", sumsPath)
+	return runProcess(nil, "gpg", args...)
+}
+
+// uploadRelease PUTs every artifact in deployDir, plus the SHA256SUMS
+// manifest and its signature, to the S3-compatible endpoint configured by
+// --upload-endpoint/--upload-bucket/--upload-prefix. Credentials are read
+// from the UPLOAD_ACCESS_KEY_ID / UPLOAD_SECRET_ACCESS_KEY environment
+// variables rather than flags, so they never show up in process listings.
+func uploadRelease(deployDir string) error {
+	infos, err := ioutil.ReadDir(deployDir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		if err := uploadFileWithRetry(filepath.Join(deployDir, info.Name()), info.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func uploadFileWithRetry(path, name string) error {
+	var err error
+	for attempt := 1; attempt <= uploadRetries; attempt++ {
+		if err = uploadFile(path, name); err == nil {
+			return nil
+		}
+		log.Printf("Upload of %s failed (attempt %d/%d): %s", name, attempt, uploadRetries, err)
+		time.Sleep(uploadRetryDelay)
+	}
+	return err
+}
+
+func uploadFile(path, name string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimRight(*uploadEndpoint, "/") + "/" + *uploadBucket + "/" + filepath.ToSlash(filepath.Join(*uploadPrefix, name))
+	req, err := http.NewRequest(http.MethodPut, url, strings.NewReader(string(data)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType(name))
+	req.ContentLength = int64(len(data))
+
+	if accessKey := os.Getenv("UPLOAD_ACCESS_KEY_ID"); accessKey != "" {
+		signAWSV4(req, data, accessKey, os.Getenv("UPLOAD_SECRET_ACCESS_KEY"), *uploadRegion, time.Now())
+	}
+
+	log.Printf("Uploading %s -> %s", path, url)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("upload of %s failed with status %s", name, resp.Status)
+	}
+	return nil
+}
+
+func contentType(name string) string {
+	if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// signAWSV4 signs req for an S3-compatible PUT using AWS Signature Version 4
+// (https://docs.aws.amazon.com/general/latest/gr/signature-version-4.html),
+// the scheme every real S3-compatible endpoint requires; plain HTTP basic
+// auth is rejected by all of them. It sets X-Amz-Date, X-Amz-Content-Sha256
+// and Authorization directly on req.
+func signAWSV4(req *http.Request, payload []byte, accessKey, secretKey, region string, t time.Time) {
+	amzDate := t.UTC().Format(amzDateFormat)
+	dateStamp := t.UTC().Format(amzDateStampFormat)
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	canonicalHeaders, signedHeaders := awsCanonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	credentialScope := fmt.Sprintf("%s/%s/%s/%s", dateStamp, region, awsService, awsRequestType)
+	stringToSign := strings.Join([]string{
+		awsSigningAlgorithm,
+		amzDate,
+		credentialScope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := awsV4SigningKey(secretKey, dateStamp, region)
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		awsSigningAlgorithm, accessKey, credentialScope, signedHeaders, signature))
+}
+
+// awsCanonicalHeaders builds the canonical header block and signed-header
+// list SigV4 requires from Host and the X-Amz-* headers set by signAWSV4,
+// names lower-cased and sorted. No other header (Content-Type included) is
+// signed.
+func awsCanonicalHeaders(req *http.Request) (string, string) {
+	headers := map[string]string{"host": req.Header.Get("Host")}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonical, "%s:%s\n", name, headers[name])
+	}
+	return canonical.String(), strings.Join(names, ";")
+}
+
+// awsV4SigningKey derives the request-scoped signing key via the
+// date/region/service/aws4_request HMAC-SHA256 chain SigV4 specifies.
+func awsV4SigningKey(secretKey, dateStamp, region string) []byte {
+	kDate := hmacSHA256([]byte("AWS4"+secretKey), dateStamp)
+	kRegion := hmacSHA256(kDate, region)
+	kService := hmacSHA256(kRegion, awsService)
+	return hmacSHA256(kService, awsRequestType)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, data)
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,137 @@
+// Copyright 2015 ThoughtWorks, Inc.
+
+// This file is part of Gauge.
+
+// Gauge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// Gauge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Gauge.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// A minimal writer for the xar archive format macOS flat installer packages
+// (.pkg) use as their container: a fixed-size header, a zlib-compressed XML
+// table of contents describing each entry's offset/size/checksums, and a
+// heap of the zlib-compressed entries themselves.
+
+const (
+	xarMagic      = "xar!"
+	xarHeaderSize = uint16(28)
+	xarVersion    = uint16(1)
+	// xarChecksumSHA1 identifies the checksum algorithm recorded in the
+	// header (1 == SHA-1, the only algorithm xar tooling reliably reads).
+	xarChecksumSHA1 = uint32(1)
+)
+
+// xarFile is a named blob to embed in a xar archive, in the order it should
+// appear in the table of contents and heap.
+type xarFile struct {
+	name string
+	data []byte
+}
+
+type xarTOCEntry struct {
+	name                        string
+	offset, size, length        int64
+	extractedSHA1, archivedSHA1 [sha1.Size]byte
+}
+
+// writeXar assembles files into a xar archive and writes it to out.
+func writeXar(out io.Writer, files []xarFile) error {
+	checksumSize := int64(sha1.Size)
+	heapOffset := checksumSize
+
+	var heap bytes.Buffer
+	entries := make([]xarTOCEntry, 0, len(files))
+	for _, f := range files {
+		compressed, err := zlibCompress(f.data)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, xarTOCEntry{
+			name:          f.name,
+			offset:        heapOffset,
+			size:          int64(len(compressed)),
+			length:        int64(len(f.data)),
+			extractedSHA1: sha1.Sum(f.data),
+			archivedSHA1:  sha1.Sum(compressed),
+		})
+		heap.Write(compressed)
+		heapOffset += int64(len(compressed))
+	}
+
+	tocXML := []byte(buildTOCXML(checksumSize, entries))
+	tocCompressed, err := zlibCompress(tocXML)
+	if err != nil {
+		return err
+	}
+	tocChecksum := sha1.Sum(tocXML)
+
+	if err := writeXarHeader(out, len(tocCompressed), len(tocXML)); err != nil {
+		return err
+	}
+	if _, err := out.Write(tocCompressed); err != nil {
+		return err
+	}
+	if _, err := out.Write(tocChecksum[:]); err != nil {
+		return err
+	}
+	_, err = out.Write(heap.Bytes())
+	return err
+}
+
+func writeXarHeader(out io.Writer, tocCompressedLen, tocLen int) error {
+	header := new(bytes.Buffer)
+	header.WriteString(xarMagic)
+	binary.Write(header, binary.BigEndian, xarHeaderSize)
+	binary.Write(header, binary.BigEndian, xarVersion)
+	binary.Write(header, binary.BigEndian, uint64(tocCompressedLen))
+	binary.Write(header, binary.BigEndian, uint64(tocLen))
+	binary.Write(header, binary.BigEndian, xarChecksumSHA1)
+	_, err := out.Write(header.Bytes())
+	return err
+}
+
+// buildTOCXML renders the xar table of contents. The checksum entry always
+// occupies the first slot of the heap, as required by the format.
+func buildTOCXML(checksumSize int64, entries []xarTOCEntry) string {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString("<xar>\n  <toc>\n")
+	fmt.Fprintf(&b, "    <checksum style=\"sha1\">\n      <offset>0</offset>\n      <size>%d</size>\n    </checksum>\n", checksumSize)
+	for _, e := range entries {
+		fmt.Fprintf(&b, "    <file>\n      <name>%s</name>\n      <data>\n        <offset>%d</offset>\n        <size>%d</size>\n        <length>%d</length>\n        <extracted-checksum style=\"sha1\">%x</extracted-checksum>\n        <archived-checksum style=\"sha1\">%x</archived-checksum>\n        <encoding style=\"application/x-gzip\"/>\n      </data>\n    </file>\n",
+			e.name, e.offset, e.size, e.length, e.extractedSHA1, e.archivedSHA1)
+	}
+	b.WriteString("  </toc>\n</xar>\n")
+	return b.String()
+}
+
+func zlibCompress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}